@@ -17,52 +17,33 @@ import (
 	"github.com/kumahq/kuma-net/transparent-proxy/config"
 )
 
-// MaxItemLen is the maximal amount of items like ports or IP ranges to include
-// or/and exclude. It's currently hardcoded to 10 as merbridge during creation
-// of this map is assigning hardcoded 244 bytes for map values:
+// MaxItemLen, Cidr, PodConfig, Cidr6 and PodConfig6 live in
+// podconfig_generated.go, generated from ebpf/gen's templates so the Go and
+// BPF C (bpf/podconfig.h) sides of these types can't drift apart. Run
+// `go generate ./...` after changing the MaxItemLen passed to the generator
+// below.
 //
-//  Cidr:        8 bytes
-//    Cidr.Net:  4 bytes
-//    Cidr.Mask: 1 byte
-//    pad:       3 bytes
-//
-//  PodConfig:                                  244 bytes
-//    PodConfig.StatusPort:                       2 bytes
-//    pad:                                        2 bytes
-//    PodConfig.ExcludeOutRanges (10x Cidr):     80 bytes
-//    PodConfig.IncludeOutRanges (10x Cidr):     80 bytes
-//    PodConfig.IncludeInPorts   (10x 2 bytes):  20 bytes
-//    PodConfig.IncludeOutPorts  (10x 2 bytes):  20 bytes
-//    PodConfig.ExcludeInPorts   (10x 2 bytes):  20 bytes
-//    PodConfig.ExcludeOutPorts  (10x 2 bytes):  20 bytes
-//
-// todo (bartsmykla): merbridge flagged this constant to be changed, so if
-//                    it will be changed, we have to update it
-const MaxItemLen = 10
+//go:generate go run ./gen -max-item-len=10 -out-go=./podconfig_generated.go -out-c=./bpf/podconfig.h
 
 // LocalPodIPSPinnedMapPathRelativeToBPFFS is a path where the local_pod_ips map
 // is pinned, it's hardcoded as "{BPFFS_path}/tc/globals/local_pod_ips" because
 // merbridge is hard-coding it as well, and we don't want to allot to change it
 // by mistake
 const LocalPodIPSPinnedMapPathRelativeToBPFFS = "/tc/globals/local_pod_ips"
-const MarkPodIPSPinnedMapPathRelativeToBPFFS = "/mark_pod_ips"
 
-type Cidr struct {
-	Net  uint32 // network order
-	Mask uint8
-	_    [3]uint8 // pad
-}
+// MarkPodIPSPinnedMapPathRelativeToBPFFS is pinned alongside local_pod_ips
+// under the same tc/globals directory, rather than at the BPFFS root, so the
+// native loader can pin every global map it loads under one directory (see
+// ebpf/loader.go's mapsPinDir) and have this path, attachSkMsgVerdict and
+// UpdateMarkPodIPs all agree on where to find it.
+const MarkPodIPSPinnedMapPathRelativeToBPFFS = "/tc/globals/mark_pod_ips"
 
-type PodConfig struct {
-	StatusPort       uint16
-	_                uint16 // pad
-	ExcludeOutRanges [MaxItemLen]Cidr
-	IncludeOutRanges [MaxItemLen]Cidr
-	IncludeInPorts   [MaxItemLen]uint16
-	IncludeOutPorts  [MaxItemLen]uint16
-	ExcludeInPorts   [MaxItemLen]uint16
-	ExcludeOutPorts  [MaxItemLen]uint16
-}
+// LocalPodIPSPinnedMapPathRelativeToBPFFSV6 and
+// MarkPodIPSPinnedMapPathRelativeToBPFFSV6 are the IPv6 counterparts of the
+// two paths above. They're pinned separately rather than sharing a single
+// dual-stack map because the key/value layout (Cidr vs Cidr6) differs.
+const LocalPodIPSPinnedMapPathRelativeToBPFFSV6 = "/tc/globals/local_pod_ips6"
+const MarkPodIPSPinnedMapPathRelativeToBPFFSV6 = "/tc/globals/mark_pod_ips6"
 
 type Program struct {
 	Name  string
@@ -124,7 +105,28 @@ func isDirEmpty(dirPath string) (bool, error) {
 	return true, nil
 }
 
-func LoadAndAttachEbpfPrograms(programs []*Program, cfg config.Config) error {
+// LoadAndAttachEbpfPrograms loads and attaches the given programs.
+//
+// By default it loads and attaches them natively, using the cilium/ebpf
+// bindings directly (see loadAndAttachEbpfProgramsNative), rewriting podIP
+// and podConfig into the programs in place of the CLI flags the exec-based
+// path below used to require. podConfig6 is populated and non-nil when
+// cfg.Ebpf.AddressFamily includes IPv6, and nil for IPv4-only pods. Setting
+// cfg.Ebpf.ExecLoader keeps the old behaviour of shelling out to a
+// pre-compiled binary per program, for compatibility with environments that
+// can't run the native loader yet.
+func LoadAndAttachEbpfPrograms(programs []*Program, cfg config.Config, podIP net.IP, podConfig PodConfig, podConfig6 *PodConfig6) error {
+	if cfg.Ebpf.ExecLoader {
+		return loadAndAttachEbpfProgramsExec(programs, cfg)
+	}
+
+	return loadAndAttachEbpfProgramsNative(programs, cfg, podIP, podConfig, podConfig6)
+}
+
+// loadAndAttachEbpfProgramsExec is the original loader: it shells out to a
+// compiled binary under cfg.Ebpf.ProgramsSourcePath for each program,
+// passing everything the program needs as CLI flags.
+func loadAndAttachEbpfProgramsExec(programs []*Program, cfg config.Config) error {
 	var errs []string
 
 	for _, p := range programs {
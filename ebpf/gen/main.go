@@ -0,0 +1,50 @@
+// Command gen renders ebpf/podconfig_generated.go and bpf/podconfig.h from
+// the templates in this directory, so the Go and BPF C sides of PodConfig
+// agree on MaxItemLen without anyone having to update both by hand.
+//
+// Invoked via `go generate ./...` (see the //go:generate directive in
+// ebpf/ebpf.go), not meant to be run directly outside of that.
+package main
+
+import (
+	"embed"
+	"flag"
+	"log"
+	"os"
+	"text/template"
+)
+
+//go:embed podconfig.go.tmpl podconfig.h.tmpl
+var templates embed.FS
+
+func main() {
+	maxItemLen := flag.Int("max-item-len", 10, "maximal amount of items (ports/CIDRs) per PodConfig field")
+	outGo := flag.String("out-go", "../podconfig_generated.go", "path to write the generated Go types to")
+	outC := flag.String("out-c", "../bpf/podconfig.h", "path to write the generated C header to")
+	flag.Parse()
+
+	data := struct{ MaxItemLen int }{MaxItemLen: *maxItemLen}
+
+	if err := render("podconfig.go.tmpl", *outGo, data); err != nil {
+		log.Fatalf("rendering %s: %v", *outGo, err)
+	}
+
+	if err := render("podconfig.h.tmpl", *outC, data); err != nil {
+		log.Fatalf("rendering %s: %v", *outC, err)
+	}
+}
+
+func render(tmplName, outPath string, data interface{}) error {
+	tmpl, err := template.ParseFS(templates, tmplName)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return tmpl.Execute(out, data)
+}
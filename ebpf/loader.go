@@ -0,0 +1,479 @@
+//go:build linux
+
+package ebpf
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"strings"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/rlimit"
+	"github.com/vishvananda/netlink"
+
+	"github.com/kumahq/kuma-net/transparent-proxy/config"
+)
+
+// bpfObjects holds the compiled BPF object files produced by
+// `make generate-ebpf` (see ebpf/bpf/). The directory is embedded wholesale
+// (including dotfiles) so the package still builds from a checkout where the
+// objects haven't been generated yet - loadNative simply finds nothing to
+// load and returns an error naming the missing program.
+//
+//go:embed all:bpf
+var bpfObjects embed.FS
+
+// attachType describes the kernel hook a compiled program should be attached
+// to once its collection has been loaded and its constants rewritten.
+type attachType uint8
+
+const (
+	attachCgroupConnect4 attachType = iota
+	attachCgroupConnect6
+	attachCgroupSockops
+	attachCgroupGetsockopt
+	attachSkMsg
+	attachTC
+)
+
+// nativePrograms maps the Program.Name already used by the exec-based loader
+// to the object file it's compiled into and the hook it's attached to, so
+// callers don't have to change how they describe the programs they want
+// loaded.
+var nativePrograms = map[string]struct {
+	object string
+	attach attachType
+}{
+	"redir":      {object: "bpf/cgroup_connect.o", attach: attachCgroupConnect4},
+	"redir6":     {object: "bpf/cgroup_connect6.o", attach: attachCgroupConnect6},
+	"sockops":    {object: "bpf/sockops.o", attach: attachCgroupSockops},
+	"getsockopt": {object: "bpf/getsockopt.o", attach: attachCgroupGetsockopt},
+	"sockmsg":    {object: "bpf/sockmsg.o", attach: attachSkMsg},
+	"tc":         {object: "bpf/tc_mark.o", attach: attachTC},
+}
+
+// mapsPinDir is the directory the native loader pins every map from a
+// loaded collection under. Pinning them all at the same directory (rather
+// than the old per-"maps" subdirectory the exec-based loader never used)
+// means programs loaded in separate loadNative calls (e.g. redir and
+// sockops) share the same local_pod_ips/mark_pod_ips instances instead of
+// each getting their own, and it's the exact directory
+// LocalPodIPSPinnedMapPathRelativeToBPFFS/MarkPodIPSPinnedMapPathRelativeToBPFFS
+// (and their V6 counterparts) already point into, so attachSkMsgVerdict and
+// UpdateLocalPodIPs/UpdateMarkPodIPs find what the native loader pinned.
+func mapsPinDir(cfg config.Config) string {
+	return cfg.Ebpf.BPFFSPath + "/tc/globals"
+}
+
+// linkPinPath returns the path an attached program's link is pinned at, so
+// a later run of the CNI plugin can find it again without reattaching. It's
+// kept under its own subdirectory rather than mapsPinDir so it never
+// collides with a map's own pin file (local_pod_ips, for example, is a file
+// directly under mapsPinDir, not a directory).
+func linkPinPath(cfg config.Config, name string) string {
+	return cfg.Ebpf.BPFFSPath + "/tc/globals/links/" + name
+}
+
+// rewriteConstants builds the set of values RewriteConstants injects into a
+// program in place of the CLI flags the exec-based loader used to pass: the
+// pod IP and the status port. Port/CIDR lists are too large to rewrite as
+// constants, so they're written into the pinned PodConfig map instead, see
+// updatePodConfig.
+//
+// Only the cgroup/connect4 and cgroup/connect6 programs match on the pod's
+// own address (to tell a self-connect from real traffic), so POD_IP/POD_IP6
+// are only rewritten for those, keyed off at, not podIP's own address
+// family - an IPv6-only pod has no v4 address at all, and requesting
+// e.g. sockops or redir6 for it must not require one.
+func rewriteConstants(at attachType, podIP net.IP, statusPort uint16) (map[string]interface{}, error) {
+	consts := map[string]interface{}{"STATUS_PORT": statusPort}
+
+	switch at {
+	case attachCgroupConnect4:
+		ip4 := podIP.To4()
+		if ip4 == nil {
+			return nil, fmt.Errorf("POD_IP rewrite requires an IPv4 address, got %q", podIP)
+		}
+
+		consts["POD_IP"] = *(*uint32)(unsafe.Pointer(&ip4[0]))
+	case attachCgroupConnect6:
+		ip16 := podIP.To16()
+		if ip16 == nil {
+			return nil, fmt.Errorf("POD_IP6 rewrite requires a valid IP address, got %q", podIP)
+		}
+
+		consts["POD_IP6"] = *(*[16]byte)(unsafe.Pointer(&ip16[0]))
+	}
+
+	return consts, nil
+}
+
+// loadNative loads, rewrites and attaches a single program using the
+// cilium/ebpf bindings directly, instead of shelling out to a pre-compiled
+// binary. Verifier rejections are returned as-is (wrapped with
+// fmt.Errorf's %w) so callers can unwrap them with errors.As to print the
+// full verifier log.
+func loadNative(p *Program, cfg config.Config, podIP net.IP, podConfig PodConfig, podConfig6 *PodConfig6) (link.Link, error) {
+	native, ok := nativePrograms[p.Name]
+	if !ok {
+		return nil, fmt.Errorf("no native implementation registered for program %q", p.Name)
+	}
+
+	objBytes, err := bpfObjects.ReadFile(native.object)
+	if err != nil {
+		return nil, fmt.Errorf("reading embedded object %q: %w", native.object, err)
+	}
+
+	spec, err := ebpf.LoadCollectionSpecFromReader(bytesReader(objBytes))
+	if err != nil {
+		return nil, fmt.Errorf("parsing collection spec for %q: %w", p.Name, err)
+	}
+
+	consts, err := rewriteConstants(native.attach, podIP, podConfig.StatusPort)
+	if err != nil {
+		return nil, fmt.Errorf("building constants for %q: %w", p.Name, err)
+	}
+
+	if err := spec.RewriteConstants(consts); err != nil {
+		return nil, fmt.Errorf("rewriting constants for %q: %w", p.Name, err)
+	}
+
+	if err := validatePodConfigCapacity(spec, "pod_config", podConfigFieldCapacities); err != nil {
+		return nil, fmt.Errorf("validating pod config capacity for %q: %w", p.Name, err)
+	}
+
+	if err := validatePodConfigCapacity(spec, "pod_config6", podConfigFieldCapacities); err != nil {
+		return nil, fmt.Errorf("validating ipv6 pod config capacity for %q: %w", p.Name, err)
+	}
+
+	coll, err := ebpf.NewCollectionWithOptions(spec, ebpf.CollectionOptions{
+		Maps: ebpf.MapOptions{PinPath: mapsPinDir(cfg)},
+	})
+	if err != nil {
+		var verifierErr *ebpf.VerifierError
+		if errors.As(err, &verifierErr) {
+			return nil, fmt.Errorf("loading collection for %q failed verification:\n%+v", p.Name, verifierErr)
+		}
+
+		return nil, fmt.Errorf("loading collection for %q: %w", p.Name, err)
+	}
+	defer coll.Close()
+
+	if err := updatePodConfig(coll, podConfig); err != nil {
+		return nil, fmt.Errorf("populating pod config map for %q: %w", p.Name, err)
+	}
+
+	if podConfig6 != nil {
+		if err := updatePodConfig6(coll, *podConfig6); err != nil {
+			return nil, fmt.Errorf("populating ipv6 pod config map for %q: %w", p.Name, err)
+		}
+	}
+
+	prog, ok := coll.Programs[p.Name]
+	if !ok {
+		return nil, fmt.Errorf("collection for %q has no program named %q", p.Name, p.Name)
+	}
+
+	l, err := attach(native.attach, prog, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("attaching %q: %w", p.Name, err)
+	}
+
+	// tc filters can't be pinned (see tcLink.Pin) - they're tied to the
+	// interface's clsact qdisc instead, which is itself persistent, so
+	// there's nothing to re-find on a later run.
+	if native.attach != attachTC {
+		if err := l.Pin(linkPinPath(cfg, p.Name)); err != nil {
+			return nil, fmt.Errorf("pinning link for %q: %w", p.Name, err)
+		}
+	}
+
+	return l, nil
+}
+
+// attach wires up a loaded program to the hook its attachType describes.
+// Cgroup based programs attach via the link package; tc is attached through
+// netlink, as there's no cgroup/link equivalent for the classifier hooks in
+// the cilium/ebpf version this module depends on.
+func attach(at attachType, prog *ebpf.Program, cfg config.Config) (link.Link, error) {
+	switch at {
+	case attachCgroupConnect4:
+		return link.AttachCgroup(link.CgroupOptions{Path: cfg.Ebpf.CgroupPath, Attach: ebpf.AttachCGroupInet4Connect, Program: prog})
+	case attachCgroupConnect6:
+		return link.AttachCgroup(link.CgroupOptions{Path: cfg.Ebpf.CgroupPath, Attach: ebpf.AttachCGroupInet6Connect, Program: prog})
+	case attachCgroupSockops:
+		return link.AttachCgroup(link.CgroupOptions{Path: cfg.Ebpf.CgroupPath, Attach: ebpf.AttachCGroupSockOps, Program: prog})
+	case attachCgroupGetsockopt:
+		return link.AttachCgroup(link.CgroupOptions{Path: cfg.Ebpf.CgroupPath, Attach: ebpf.AttachCGroupGetsockopt, Program: prog})
+	case attachSkMsg:
+		return attachSkMsgVerdict(prog, cfg)
+	case attachTC:
+		return attachTCFilter(prog, cfg)
+	default:
+		return nil, fmt.Errorf("unknown attach type: %d", at)
+	}
+}
+
+// attachSkMsgVerdict attaches a sk_msg program to the sockmap backing
+// mark_pod_ips, so redirected traffic between local pods can skip the
+// regular TCP/IP stack.
+func attachSkMsgVerdict(prog *ebpf.Program, cfg config.Config) (link.Link, error) {
+	m, err := ebpf.LoadPinnedMap(cfg.Ebpf.BPFFSPath+MarkPodIPSPinnedMapPathRelativeToBPFFS, nil)
+	if err != nil {
+		return nil, fmt.Errorf("loading pinned mark_pod_ips map: %w", err)
+	}
+	defer m.Close()
+
+	return link.RawAttachProgram(link.RawAttachProgramOptions{
+		Target:  m.FD(),
+		Program: prog,
+		Attach:  ebpf.AttachSkMsgVerdict,
+	})
+}
+
+// tcLink adapts a netlink tc filter to the link.Link interface so
+// attachTCFilter's caller can pin/close it the same way as the cgroup links.
+type tcLink struct {
+	link   netlink.Filter
+	handle *netlink.Handle
+}
+
+func (t *tcLink) Close() error { return t.handle.FilterDel(t.link) }
+func (t *tcLink) Pin(string) error {
+	return fmt.Errorf("pinning tc filters natively is not supported, they are tied to the interface's clsact qdisc")
+}
+func (t *tcLink) Unpin() error { return nil }
+func (t *tcLink) Info() (*link.Info, error) {
+	return nil, fmt.Errorf("tc filters don't expose link.Info")
+}
+func (t *tcLink) Update(*ebpf.Program) error {
+	return fmt.Errorf("updating tc filters is not supported, reattach instead")
+}
+
+// attachTCFilter attaches a direct-action classifier to the clsact qdisc of
+// the interface named in cfg, creating the qdisc if it doesn't exist yet.
+func attachTCFilter(prog *ebpf.Program, cfg config.Config) (link.Link, error) {
+	iface, err := netlink.LinkByName(cfg.Ebpf.TCAttachIface)
+	if err != nil {
+		return nil, fmt.Errorf("looking up interface %q: %w", cfg.Ebpf.TCAttachIface, err)
+	}
+
+	qdisc := &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{LinkIndex: iface.Attrs().Index, Parent: netlink.HANDLE_CLSACT},
+		QdiscType:  "clsact",
+	}
+	if err := netlink.QdiscReplace(qdisc); err != nil {
+		return nil, fmt.Errorf("ensuring clsact qdisc on %q: %w", cfg.Ebpf.TCAttachIface, err)
+	}
+
+	filter := &netlink.BpfFilter{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: iface.Attrs().Index,
+			Parent:    netlink.HANDLE_MIN_EGRESS,
+			Handle:    1,
+			Protocol:  3, // ETH_P_ALL
+		},
+		Fd:           prog.FD(),
+		Name:         prog.String(),
+		DirectAction: true,
+	}
+	if err := netlink.FilterReplace(filter); err != nil {
+		return nil, fmt.Errorf("replacing tc filter on %q: %w", cfg.Ebpf.TCAttachIface, err)
+	}
+
+	return &tcLink{link: filter, handle: &netlink.Handle{}}, nil
+}
+
+// podConfigFieldCapacities maps the pod_config/pod_config6 C structs' array
+// field names (both share the same names - only the cidr/cidr6 element type
+// differs) to the capacity this binary was built for (MaxItemLen), so
+// validatePodConfigCapacity can name the offending field if the loaded
+// object disagrees.
+var podConfigFieldCapacities = map[string]int{
+	"exclude_out_ranges": MaxItemLen,
+	"include_out_ranges": MaxItemLen,
+	"include_in_ports":   MaxItemLen,
+	"include_out_ports":  MaxItemLen,
+	"exclude_in_ports":   MaxItemLen,
+	"exclude_out_ports":  MaxItemLen,
+}
+
+// validatePodConfigCapacity reads the BTF of mapName's value type and checks
+// every array field named in capacities has the expected number of
+// elements. It catches a stale bpf/podconfig.h (compiled with a different
+// MaxItemLen than the Go side was generated for) before it causes
+// out-of-bounds map writes, rejecting the config with an error naming the
+// offending field.
+func validatePodConfigCapacity(spec *ebpf.CollectionSpec, mapName string, capacities map[string]int) error {
+	mapSpec, ok := spec.Maps[mapName]
+	if !ok {
+		// not every program carries the map, e.g. the tc classifier only
+		// reads local_pod_ips/mark_pod_ips
+		return nil
+	}
+
+	st, ok := mapSpec.Value.(*btf.Struct)
+	if !ok {
+		return fmt.Errorf("map %q has no BTF struct value type, can't validate its capacity", mapName)
+	}
+
+	for _, member := range st.Members {
+		wantLen, ok := capacities[member.Name]
+		if !ok {
+			continue
+		}
+
+		arr, ok := member.Type.(*btf.Array)
+		if !ok {
+			continue
+		}
+
+		if int(arr.Nelems) != wantLen {
+			return fmt.Errorf(
+				"field %q has capacity for %d items, but this binary was generated for %d: "+
+					"regenerate bpf/podconfig.h with the same MaxItemLen (see ebpf/gen)",
+				member.Name, arr.Nelems, wantLen,
+			)
+		}
+	}
+
+	return nil
+}
+
+// updatePodConfig writes the exclude/include ranges and ports a pod was
+// configured with into the PodConfig map a freshly loaded collection pins,
+// replacing the CLI flags the exec-based binaries used to parse.
+func updatePodConfig(coll *ebpf.Collection, podConfig PodConfig) error {
+	m, ok := coll.Maps["pod_config"]
+	if !ok {
+		// not every program carries the map, e.g. the tc classifier only
+		// reads local_pod_ips/mark_pod_ips
+		return nil
+	}
+
+	var key uint32
+	return m.Put(&key, &podConfig)
+}
+
+// updatePodConfig6 is updatePodConfig for the IPv6-specific pod_config6 map,
+// populated alongside pod_config when cfg.Ebpf.AddressFamily includes IPv6.
+func updatePodConfig6(coll *ebpf.Collection, podConfig6 PodConfig6) error {
+	m, ok := coll.Maps["pod_config6"]
+	if !ok {
+		return nil
+	}
+
+	var key uint32
+	return m.Put(&key, &podConfig6)
+}
+
+// loadAndAttachEbpfProgramsNative loads, attaches and pins every requested
+// program in-process. It hot-updates the local_pod_ips/mark_pod_ips maps
+// through UpdateLocalPodIPs/UpdateMarkPodIPs instead of requiring a process
+// restart when pods come and go.
+func loadAndAttachEbpfProgramsNative(programs []*Program, cfg config.Config, podIP net.IP, podConfig PodConfig, podConfig6 *PodConfig6) error {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		return fmt.Errorf("removing memlock rlimit: %w", err)
+	}
+
+	var errs []string
+	var links []link.Link
+
+	for _, p := range programs {
+		l, err := loadNative(p, cfg, podIP, podConfig, podConfig6)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		links = append(links, l)
+	}
+
+	if len(errs) > 0 {
+		for _, l := range links {
+			_ = l.Close()
+		}
+
+		return fmt.Errorf("loading and attaching bpf programs natively failed:\n%s", strings.Join(errs, "\n\t"))
+	}
+
+	return nil
+}
+
+// bytesReader adapts a []byte to the io.ReaderAt LoadCollectionSpecFromReader
+// expects.
+func bytesReader(b []byte) *bytesReaderAt {
+	return &bytesReaderAt{b: b}
+}
+
+type bytesReaderAt struct{ b []byte }
+
+func (r *bytesReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(r.b)) {
+		return 0, fs.ErrClosed
+	}
+
+	return copy(p, r.b[off:]), nil
+}
+
+// UpdateLocalPodIPs adds or removes ip from the pinned local_pod_ips map,
+// so the programs already attached to a node pick up pods coming and going
+// without needing to be reattached.
+func UpdateLocalPodIPs(cfg config.Config, ip net.IP, remove bool) error {
+	return updatePinnedIPMap(cfg.Ebpf.BPFFSPath+LocalPodIPSPinnedMapPathRelativeToBPFFS, ip, remove)
+}
+
+// UpdateMarkPodIPs adds or removes ip from the pinned mark_pod_ips map, used
+// by the sk_msg program to decide which local connections it should
+// redirect.
+func UpdateMarkPodIPs(cfg config.Config, ip net.IP, remove bool) error {
+	return updatePinnedIPMap(cfg.Ebpf.BPFFSPath+MarkPodIPSPinnedMapPathRelativeToBPFFS, ip, remove)
+}
+
+// UpdateLocalPodIPs6 is UpdateLocalPodIPs for the IPv6-specific
+// local_pod_ips6 map, used when cfg.Ebpf.AddressFamily includes IPv6.
+func UpdateLocalPodIPs6(cfg config.Config, ip net.IP, remove bool) error {
+	return updatePinnedIPMap(cfg.Ebpf.BPFFSPath+LocalPodIPSPinnedMapPathRelativeToBPFFSV6, ip, remove)
+}
+
+// UpdateMarkPodIPs6 is UpdateMarkPodIPs for the IPv6-specific mark_pod_ips6
+// map, used when cfg.Ebpf.AddressFamily includes IPv6.
+func UpdateMarkPodIPs6(cfg config.Config, ip net.IP, remove bool) error {
+	return updatePinnedIPMap(cfg.Ebpf.BPFFSPath+MarkPodIPSPinnedMapPathRelativeToBPFFSV6, ip, remove)
+}
+
+func updatePinnedIPMap(path string, ip net.IP, remove bool) error {
+	m, err := ebpf.LoadPinnedMap(path, nil)
+	if err != nil {
+		return fmt.Errorf("loading pinned map %q: %w", path, err)
+	}
+	defer m.Close()
+
+	ptr, err := ipStrToPtr(ip.String())
+	if err != nil {
+		return err
+	}
+
+	key := *(*[16]byte)(ptr)
+
+	if remove {
+		if err := m.Delete(&key); err != nil {
+			return fmt.Errorf("removing %s from %q: %w", ip, path, err)
+		}
+
+		return nil
+	}
+
+	var value uint32 = 1
+	if err := m.Put(&key, &value); err != nil {
+		return fmt.Errorf("adding %s to %q: %w", ip, path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+//go:build linux
+
+package ebpf
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRewriteConstants(t *testing.T) {
+	cases := []struct {
+		name    string
+		at      attachType
+		podIP   net.IP
+		wantErr bool
+		want    map[string]interface{}
+	}{
+		{
+			name:  "cgroup connect4 rewrites POD_IP from a v4 address",
+			at:    attachCgroupConnect4,
+			podIP: net.ParseIP("10.0.0.1"),
+			want: map[string]interface{}{
+				"STATUS_PORT": uint16(15006),
+				"POD_IP":      uint32(0x0100000a),
+			},
+		},
+		{
+			name:    "cgroup connect4 rejects a v6-only address",
+			at:      attachCgroupConnect4,
+			podIP:   net.ParseIP("fd00::1"),
+			wantErr: true,
+		},
+		{
+			name:  "cgroup connect6 rewrites POD_IP6 from a v6 address",
+			at:    attachCgroupConnect6,
+			podIP: net.ParseIP("fd00::1"),
+			want: map[string]interface{}{
+				"STATUS_PORT": uint16(15006),
+				"POD_IP6": [16]byte{
+					0xfd, 0x00, 0, 0, 0, 0, 0, 0,
+					0, 0, 0, 0, 0, 0, 0, 1,
+				},
+			},
+		},
+		{
+			name:  "cgroup connect6 accepts a v4 address (v4-in-v6 mapped)",
+			at:    attachCgroupConnect6,
+			podIP: net.ParseIP("10.0.0.1"),
+			want: map[string]interface{}{
+				"STATUS_PORT": uint16(15006),
+				"POD_IP6": [16]byte{
+					0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff,
+					10, 0, 0, 1,
+				},
+			},
+		},
+		{
+			name:  "sockops needs neither POD_IP nor POD_IP6 for an IPv6-only pod",
+			at:    attachCgroupSockops,
+			podIP: net.ParseIP("fd00::1"),
+			want: map[string]interface{}{
+				"STATUS_PORT": uint16(15006),
+			},
+		},
+		{
+			name:  "tc needs neither POD_IP nor POD_IP6 for an IPv6-only pod",
+			at:    attachTC,
+			podIP: net.ParseIP("fd00::1"),
+			want: map[string]interface{}{
+				"STATUS_PORT": uint16(15006),
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := rewriteConstants(c.at, c.podIP, 15006)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(got) != len(c.want) {
+				t.Fatalf("got %d constants, want %d: %+v", len(got), len(c.want), got)
+			}
+
+			for k, v := range c.want {
+				if got[k] != v {
+					t.Errorf("constant %q = %#v, want %#v", k, got[k], v)
+				}
+			}
+		})
+	}
+}
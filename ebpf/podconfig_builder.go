@@ -0,0 +1,155 @@
+//go:build linux
+
+package ebpf
+
+import "fmt"
+
+// ErrTooManyItems is returned by PodConfigBuilder's Add* methods once a
+// field already holds MaxItemLen items, so callers get a real diagnostic
+// instead of entries being silently dropped past a PodConfig's fixed-size
+// arrays.
+var ErrTooManyItems = fmt.Errorf("exceeded the %d item capacity for this field", MaxItemLen)
+
+// podConfigCore holds the bounds-checked state shared by PodConfigBuilder
+// and PodConfig6Builder: both assemble identically shaped fixed-size arrays
+// one item at a time, differing only in the range type (Cidr vs Cidr6).
+// Keeping that logic in one generic place means it can't drift out of sync
+// between the two the way the hand-duplicated builders used to.
+type podConfigCore[R any] struct {
+	statusPort uint16
+
+	excludeOutRanges  [MaxItemLen]R
+	nExcludeOutRanges int
+	includeOutRanges  [MaxItemLen]R
+	nIncludeOutRanges int
+	includeInPorts    [MaxItemLen]uint16
+	nIncludeInPorts   int
+	includeOutPorts   [MaxItemLen]uint16
+	nIncludeOutPorts  int
+	excludeInPorts    [MaxItemLen]uint16
+	nExcludeInPorts   int
+	excludeOutPorts   [MaxItemLen]uint16
+	nExcludeOutPorts  int
+}
+
+func newPodConfigCore[R any](statusPort uint16) podConfigCore[R] {
+	return podConfigCore[R]{statusPort: statusPort}
+}
+
+func addRange[R any](arr *[MaxItemLen]R, n *int, field string, v R) error {
+	if *n >= MaxItemLen {
+		return fmt.Errorf("%s: %w", field, ErrTooManyItems)
+	}
+
+	arr[*n] = v
+	*n++
+
+	return nil
+}
+
+func addPort(arr *[MaxItemLen]uint16, n *int, field string, port uint16) error {
+	if *n >= MaxItemLen {
+		return fmt.Errorf("%s: %w", field, ErrTooManyItems)
+	}
+
+	arr[*n] = port
+	*n++
+
+	return nil
+}
+
+// PodConfigBuilder builds a PodConfig one item at a time, rejecting
+// additions that would overflow a field's MaxItemLen capacity instead of
+// truncating them.
+type PodConfigBuilder struct {
+	core podConfigCore[Cidr]
+}
+
+func NewPodConfigBuilder(statusPort uint16) *PodConfigBuilder {
+	return &PodConfigBuilder{core: newPodConfigCore[Cidr](statusPort)}
+}
+
+func (b *PodConfigBuilder) AddExcludeOutRange(c Cidr) error {
+	return addRange(&b.core.excludeOutRanges, &b.core.nExcludeOutRanges, "ExcludeOutRanges", c)
+}
+
+func (b *PodConfigBuilder) AddIncludeOutRange(c Cidr) error {
+	return addRange(&b.core.includeOutRanges, &b.core.nIncludeOutRanges, "IncludeOutRanges", c)
+}
+
+func (b *PodConfigBuilder) AddIncludeInPort(port uint16) error {
+	return addPort(&b.core.includeInPorts, &b.core.nIncludeInPorts, "IncludeInPorts", port)
+}
+
+func (b *PodConfigBuilder) AddIncludeOutPort(port uint16) error {
+	return addPort(&b.core.includeOutPorts, &b.core.nIncludeOutPorts, "IncludeOutPorts", port)
+}
+
+func (b *PodConfigBuilder) AddExcludeInPort(port uint16) error {
+	return addPort(&b.core.excludeInPorts, &b.core.nExcludeInPorts, "ExcludeInPorts", port)
+}
+
+func (b *PodConfigBuilder) AddExcludeOutPort(port uint16) error {
+	return addPort(&b.core.excludeOutPorts, &b.core.nExcludeOutPorts, "ExcludeOutPorts", port)
+}
+
+// Build returns the PodConfig assembled so far.
+func (b *PodConfigBuilder) Build() PodConfig {
+	return PodConfig{
+		StatusPort:       b.core.statusPort,
+		ExcludeOutRanges: b.core.excludeOutRanges,
+		IncludeOutRanges: b.core.includeOutRanges,
+		IncludeInPorts:   b.core.includeInPorts,
+		IncludeOutPorts:  b.core.includeOutPorts,
+		ExcludeInPorts:   b.core.excludeInPorts,
+		ExcludeOutPorts:  b.core.excludeOutPorts,
+	}
+}
+
+// PodConfig6Builder mirrors PodConfigBuilder for the IPv6-specific
+// PodConfig6, so IPv6 ranges/ports get the same ErrTooManyItems protection
+// instead of being written by raw array indexing.
+type PodConfig6Builder struct {
+	core podConfigCore[Cidr6]
+}
+
+func NewPodConfig6Builder(statusPort uint16) *PodConfig6Builder {
+	return &PodConfig6Builder{core: newPodConfigCore[Cidr6](statusPort)}
+}
+
+func (b *PodConfig6Builder) AddExcludeOutRange(c Cidr6) error {
+	return addRange(&b.core.excludeOutRanges, &b.core.nExcludeOutRanges, "ExcludeOutRanges", c)
+}
+
+func (b *PodConfig6Builder) AddIncludeOutRange(c Cidr6) error {
+	return addRange(&b.core.includeOutRanges, &b.core.nIncludeOutRanges, "IncludeOutRanges", c)
+}
+
+func (b *PodConfig6Builder) AddIncludeInPort(port uint16) error {
+	return addPort(&b.core.includeInPorts, &b.core.nIncludeInPorts, "IncludeInPorts", port)
+}
+
+func (b *PodConfig6Builder) AddIncludeOutPort(port uint16) error {
+	return addPort(&b.core.includeOutPorts, &b.core.nIncludeOutPorts, "IncludeOutPorts", port)
+}
+
+func (b *PodConfig6Builder) AddExcludeInPort(port uint16) error {
+	return addPort(&b.core.excludeInPorts, &b.core.nExcludeInPorts, "ExcludeInPorts", port)
+}
+
+func (b *PodConfig6Builder) AddExcludeOutPort(port uint16) error {
+	return addPort(&b.core.excludeOutPorts, &b.core.nExcludeOutPorts, "ExcludeOutPorts", port)
+}
+
+// Build returns the PodConfig6 assembled so far.
+func (b *PodConfig6Builder) Build() PodConfig6 {
+	return PodConfig6{
+		StatusPort:       b.core.statusPort,
+		ExcludeOutRanges: b.core.excludeOutRanges,
+		IncludeOutRanges: b.core.includeOutRanges,
+		IncludeInPorts:   b.core.includeInPorts,
+		IncludeOutPorts:  b.core.includeOutPorts,
+		ExcludeInPorts:   b.core.excludeInPorts,
+		ExcludeOutPorts:  b.core.excludeOutPorts,
+	}
+}
@@ -0,0 +1,79 @@
+//go:build linux
+
+package ebpf
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPodConfigBuilderOverflow(t *testing.T) {
+	b := NewPodConfigBuilder(15006)
+
+	for i := 0; i < MaxItemLen; i++ {
+		if err := b.AddIncludeInPort(uint16(i)); err != nil {
+			t.Fatalf("unexpected error on item %d: %v", i, err)
+		}
+	}
+
+	if err := b.AddIncludeInPort(12345); !errors.Is(err, ErrTooManyItems) {
+		t.Fatalf("got err %v, want ErrTooManyItems", err)
+	}
+
+	cfg := b.Build()
+	if cfg.IncludeInPorts[0] != 0 || cfg.IncludeInPorts[MaxItemLen-1] != uint16(MaxItemLen-1) {
+		t.Fatalf("unexpected IncludeInPorts contents: %v", cfg.IncludeInPorts)
+	}
+}
+
+func TestPodConfigBuilderRanges(t *testing.T) {
+	b := NewPodConfigBuilder(0)
+
+	for i := 0; i < MaxItemLen; i++ {
+		if err := b.AddExcludeOutRange(Cidr{Net: uint32(i), Mask: 24}); err != nil {
+			t.Fatalf("unexpected error on item %d: %v", i, err)
+		}
+	}
+
+	if err := b.AddExcludeOutRange(Cidr{Net: 1, Mask: 32}); !errors.Is(err, ErrTooManyItems) {
+		t.Fatalf("got err %v, want ErrTooManyItems", err)
+	}
+
+	cfg := b.Build()
+	if cfg.ExcludeOutRanges[MaxItemLen-1].Net != uint32(MaxItemLen-1) {
+		t.Fatalf("unexpected ExcludeOutRanges contents: %+v", cfg.ExcludeOutRanges)
+	}
+}
+
+func TestPodConfig6BuilderOverflow(t *testing.T) {
+	b := NewPodConfig6Builder(15006)
+
+	for i := 0; i < MaxItemLen; i++ {
+		if err := b.AddIncludeOutPort(uint16(i)); err != nil {
+			t.Fatalf("unexpected error on item %d: %v", i, err)
+		}
+	}
+
+	if err := b.AddIncludeOutPort(12345); !errors.Is(err, ErrTooManyItems) {
+		t.Fatalf("got err %v, want ErrTooManyItems", err)
+	}
+
+	cfg := b.Build()
+	if cfg.IncludeOutPorts[MaxItemLen-1] != uint16(MaxItemLen-1) {
+		t.Fatalf("unexpected IncludeOutPorts contents: %v", cfg.IncludeOutPorts)
+	}
+}
+
+func TestPodConfig6BuilderRanges(t *testing.T) {
+	b := NewPodConfig6Builder(0)
+
+	want := Cidr6{Net: [16]byte{0xfd, 0x00}, Mask: 64}
+	if err := b.AddIncludeOutRange(want); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cfg := b.Build()
+	if cfg.IncludeOutRanges[0] != want {
+		t.Fatalf("got %+v, want %+v", cfg.IncludeOutRanges[0], want)
+	}
+}
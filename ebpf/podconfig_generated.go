@@ -0,0 +1,51 @@
+// Code generated by ebpf/gen from podconfig.go.tmpl; DO NOT EDIT.
+
+//go:build linux
+
+package ebpf
+
+// MaxItemLen is the maximal amount of items like ports or IP ranges to
+// include or/and exclude per PodConfig/PodConfig6 field. It's a build-time
+// parameter of this generator (see ebpf/gen) rather than a Go constant you
+// can bump directly, because the BPF side (bpf/podconfig.h) has to agree on
+// the exact same value or the two sides' struct layouts will diverge.
+const MaxItemLen = 10
+
+type Cidr struct {
+	Net  uint32 // network order
+	Mask uint8
+	_    [3]uint8 // pad
+}
+
+type PodConfig struct {
+	StatusPort       uint16
+	_                uint16 // pad
+	ExcludeOutRanges [MaxItemLen]Cidr
+	IncludeOutRanges [MaxItemLen]Cidr
+	IncludeInPorts   [MaxItemLen]uint16
+	IncludeOutPorts  [MaxItemLen]uint16
+	ExcludeInPorts   [MaxItemLen]uint16
+	ExcludeOutPorts  [MaxItemLen]uint16
+}
+
+// Cidr6 is the IPv6 equivalent of Cidr: a 16-byte network address (network
+// order) plus a prefix length instead of Cidr's 4-byte address.
+type Cidr6 struct {
+	Net  [16]byte // network order
+	Mask uint8
+	_    [7]uint8 // pad, keeps the struct 8-byte aligned like Cidr does for uint32
+}
+
+// PodConfig6 mirrors PodConfig with Cidr6 ranges, for pods that should be
+// matched on their IPv6 address instead of (or in addition to) their IPv4
+// one.
+type PodConfig6 struct {
+	StatusPort       uint16
+	_                uint16 // pad
+	ExcludeOutRanges [MaxItemLen]Cidr6
+	IncludeOutRanges [MaxItemLen]Cidr6
+	IncludeInPorts   [MaxItemLen]uint16
+	IncludeOutPorts  [MaxItemLen]uint16
+	ExcludeInPorts   [MaxItemLen]uint16
+	ExcludeOutPorts  [MaxItemLen]uint16
+}
@@ -0,0 +1,204 @@
+package table
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Backend picks which tool a rendered ruleset is meant to be fed to.
+// NFTables produces a "nft -f" script via BuildNFT, while the zero value
+// (IPTables) keeps using Build's iptables-restore/ip6tables-restore output.
+type Backend string
+
+const (
+	IPTables Backend = "iptables"
+	NFTables Backend = "nftables"
+)
+
+// hookHeaders maps the nat table's hardcoded base chains to the nft hook
+// and priority that makes them fire at the same point iptables' PREROUTING/
+// INPUT/OUTPUT/POSTROUTING chains do for the nat table.
+var hookHeaders = map[string]string{
+	"PREROUTING":  "type nat hook prerouting priority dstnat; policy accept;",
+	"INPUT":       "type nat hook input priority 100; policy accept;",
+	"OUTPUT":      "type nat hook output priority -100; policy accept;",
+	"POSTROUTING": "type nat hook postrouting priority srcnat; policy accept;",
+}
+
+// WithBackend selects which backend Render uses for this table, overriding
+// the DetectBackend auto-detection Render otherwise falls back to.
+func (t *NatTable) WithBackend(b Backend) *NatTable {
+	t.backend = b
+
+	return t
+}
+
+// Render renders the table for whichever Backend was selected via
+// WithBackend, auto-detecting one with DetectBackend if none was set. It's
+// the single entry point callers need - they no longer have to know to call
+// BuildNFT themselves to install the transparent-proxy rules without the
+// legacy iptables shim on nft-only distros.
+func (t *NatTable) Render(verbose bool) (string, error) {
+	backend := t.backend
+	if backend == "" {
+		backend = DetectBackend()
+	}
+
+	switch backend {
+	case NFTables:
+		return t.BuildNFT(verbose)
+	case IPTables:
+		return t.Build(verbose), nil
+	default:
+		return "", fmt.Errorf("unknown backend %q", backend)
+	}
+}
+
+// BuildNFT renders the same ruleset Build produces, but as an nft -f script
+// instead of an iptables-restore one, for operators on nft-only distros
+// (RHEL 9, recent Debian/Ubuntu) who don't have the legacy iptables shim.
+func (t *NatTable) BuildNFT(verbose bool) (string, error) {
+	lines := []string{"table inet kuma_nat {"}
+
+	for _, c := range []*struct {
+		name string
+		c    interface{ Build(bool) []string }
+	}{
+		{"PREROUTING", t.prerouting},
+		{"INPUT", t.input},
+		{"OUTPUT", t.output},
+		{"POSTROUTING", t.postrouting},
+	} {
+		chainLines, err := nftChain(c.name, hookHeaders[c.name], c.c.Build(verbose))
+		if err != nil {
+			return "", fmt.Errorf("translating chain %q: %w", c.name, err)
+		}
+
+		lines = append(lines, chainLines...)
+	}
+
+	for _, custom := range t.chains {
+		chainLines, err := nftChain(custom.String(), "", custom.Build(verbose))
+		if err != nil {
+			return "", fmt.Errorf("translating custom chain %q: %w", custom.String(), err)
+		}
+
+		lines = append(lines, chainLines...)
+	}
+
+	lines = append(lines, "}")
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func nftChain(name, header string, iptablesRules []string) ([]string, error) {
+	lines := []string{fmt.Sprintf("\tchain %s {", name)}
+
+	if header != "" {
+		lines = append(lines, "\t\t"+header)
+	}
+
+	for _, rule := range iptablesRules {
+		nftRule, err := translateRule(rule)
+		if err != nil {
+			return nil, err
+		}
+
+		if nftRule != "" {
+			lines = append(lines, "\t\t"+nftRule)
+		}
+	}
+
+	lines = append(lines, "\t}")
+
+	return lines, nil
+}
+
+// translateRule turns a single "-A <chain> ..." iptables-restore rule line
+// into its nft equivalent. It only understands the subset of matches and
+// targets the transparent-proxy chains actually emit: -p, -d/-s, --dport/
+// --sport, -m owner --uid-owner, -m mark --mark, and the REDIRECT/RETURN
+// targets.
+func translateRule(rule string) (string, error) {
+	fields := strings.Fields(rule)
+	if len(fields) < 2 || fields[0] != "-A" {
+		return "", fmt.Errorf("unsupported rule format: %q", rule)
+	}
+
+	var parts []string
+
+	for i := 2; i < len(fields); i++ {
+		switch fields[i] {
+		case "-p":
+			i++
+			parts = append(parts, fmt.Sprintf("meta l4proto %s", fields[i]))
+		case "-d":
+			i++
+			parts = append(parts, fmt.Sprintf("ip daddr %s", fields[i]))
+		case "-s":
+			i++
+			parts = append(parts, fmt.Sprintf("ip saddr %s", fields[i]))
+		case "--dport":
+			i++
+			parts = append(parts, fmt.Sprintf("th dport %s", fields[i]))
+		case "--sport":
+			i++
+			parts = append(parts, fmt.Sprintf("th sport %s", fields[i]))
+		case "-m":
+			i++ // match module name itself (owner, mark, ...) carries no rule text
+		case "--uid-owner":
+			i++
+			parts = append(parts, fmt.Sprintf("meta skuid %s", fields[i]))
+		case "--mark":
+			i++
+			parts = append(parts, fmt.Sprintf("meta mark %s", fields[i]))
+		case "-j":
+			i++
+			target, err := translateTarget(fields[i], fields[i+1:])
+			if err != nil {
+				return "", err
+			}
+
+			parts = append(parts, target)
+
+			return strings.Join(parts, " "), nil
+		default:
+			return "", fmt.Errorf("unsupported flag %q in rule: %q", fields[i], rule)
+		}
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+func translateTarget(target string, rest []string) (string, error) {
+	switch target {
+	case "RETURN":
+		return "return", nil
+	case "REDIRECT":
+		if len(rest) >= 2 && rest[0] == "--to-ports" {
+			return fmt.Sprintf("redirect to :%s", rest[1]), nil
+		}
+
+		return "redirect", nil
+	default:
+		// custom chain jump
+		return fmt.Sprintf("jump %s", target), nil
+	}
+}
+
+// DetectBackend picks Backend automatically: NFTables if the nft binary is
+// present and the kernel exposes the nf_tables subsystem, IPTables
+// otherwise (including when only the legacy iptables binary is available).
+func DetectBackend() Backend {
+	if _, err := exec.LookPath("nft"); err != nil {
+		return IPTables
+	}
+
+	if _, err := os.Stat("/proc/net/netfilter/nf_tables"); err != nil {
+		return IPTables
+	}
+
+	return NFTables
+}
@@ -0,0 +1,129 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTranslateRule(t *testing.T) {
+	cases := []struct {
+		name    string
+		rule    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "redirect with uid match",
+			rule: "-A OUTPUT -p tcp -m owner --uid-owner 5678 -j REDIRECT --to-ports 15001",
+			want: "meta l4proto tcp meta skuid 5678 redirect to :15001",
+		},
+		{
+			name: "destination and port match with return",
+			rule: "-A PREROUTING -p tcp -d 10.0.0.1 --dport 80 -j RETURN",
+			want: "meta l4proto tcp ip daddr 10.0.0.1 th dport 80 return",
+		},
+		{
+			name: "source and sport match with mark, jump to custom chain",
+			rule: "-A POSTROUTING -p tcp -s 10.0.0.2 --sport 443 -m mark --mark 0x1 -j KUMA_CUSTOM_CHAIN",
+			want: "meta l4proto tcp ip saddr 10.0.0.2 th sport 443 meta mark 0x1 jump KUMA_CUSTOM_CHAIN",
+		},
+		{
+			name: "bare redirect",
+			rule: "-A OUTPUT -j REDIRECT",
+			want: "redirect",
+		},
+		{
+			name:    "not an append rule",
+			rule:    "-N KUMA_CUSTOM_CHAIN",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported flag",
+			rule:    "-A OUTPUT -p tcp -i eth0 -j RETURN",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := translateRule(c.rule)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none (result: %q)", got)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != c.want {
+				t.Errorf("translateRule(%q) = %q, want %q", c.rule, got, c.want)
+			}
+		})
+	}
+}
+
+func TestTranslateTarget(t *testing.T) {
+	cases := []struct {
+		name    string
+		target  string
+		rest    []string
+		want    string
+		wantErr bool
+	}{
+		{name: "return", target: "RETURN", want: "return"},
+		{name: "redirect with port", target: "REDIRECT", rest: []string{"--to-ports", "15006"}, want: "redirect to :15006"},
+		{name: "bare redirect", target: "REDIRECT", want: "redirect"},
+		{name: "custom chain jump", target: "KUMA_CUSTOM_CHAIN", want: "jump KUMA_CUSTOM_CHAIN"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := translateTarget(c.target, c.rest)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none (result: %q)", got)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got != c.want {
+				t.Errorf("translateTarget(%q, %v) = %q, want %q", c.target, c.rest, got, c.want)
+			}
+		})
+	}
+}
+
+func TestNftChain(t *testing.T) {
+	got, err := nftChain("PREROUTING", hookHeaders["PREROUTING"], []string{
+		"-A PREROUTING -p tcp -d 10.0.0.1 --dport 80 -j RETURN",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{
+		"\tchain PREROUTING {",
+		"\t\ttype nat hook prerouting priority dstnat; policy accept;",
+		"\t\tmeta l4proto tcp ip daddr 10.0.0.1 th dport 80 return",
+		"\t}",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("nftChain() = %#v, want %#v", got, want)
+	}
+}
+
+func TestNftChainRejectsUnsupportedRule(t *testing.T) {
+	if _, err := nftChain("OUTPUT", "", []string{"-A OUTPUT -p tcp -i eth0 -j RETURN"}); err == nil {
+		t.Fatalf("expected an error translating an unsupported rule, got none")
+	}
+}
@@ -8,18 +8,40 @@ import (
 	. "github.com/kumahq/kuma-net/iptables/consts"
 )
 
+// AddressFamily picks which IP version a TableBuilder/NatTable renders rules
+// for. The rule set itself (chains, matches, targets) is identical between
+// the two - only the restore-file header differs, matching how
+// iptables-restore and ip6tables-restore consume the same "* nat" / "COMMIT"
+// framing for their respective address families.
+type AddressFamily string
+
+const (
+	IPv4 AddressFamily = "ip4"
+	IPv6 AddressFamily = "ip6"
+)
+
 type TableBuilder struct {
-	name string
+	name   string
+	family AddressFamily
 
 	newChains []*chain.ChainBuilder
 	chains    []*chain.ChainBuilder
 }
 
+// restoreBinary maps an AddressFamily to the restore binary a rendered
+// ruleset is meant to be fed to, so Build can leave a comment naming it
+// instead of family being inert metadata on NatTable.
+var restoreBinary = map[AddressFamily]string{
+	IPv4: "iptables-restore",
+	IPv6: "ip6tables-restore",
+}
+
 // Build
 // TODO (bartsmykla): refactor
 // TODO (bartsmykla): add tests
 func (b *TableBuilder) Build(verbose bool) string {
 	tableLine := fmt.Sprintf("* %s", b.name)
+	familyLine := fmt.Sprintf("# generated for %s", restoreBinary[b.family])
 	var newChainLines []string
 	var ruleLines []string
 
@@ -47,7 +69,7 @@ func (b *TableBuilder) Build(verbose bool) string {
 		}
 	}
 
-	lines := []string{tableLine}
+	lines := []string{familyLine, tableLine}
 
 	newChains := strings.Join(newChainLines, "\n")
 	if newChains != "" {
@@ -69,6 +91,9 @@ func (b *TableBuilder) Build(verbose bool) string {
 }
 
 type NatTable struct {
+	family  AddressFamily
+	backend Backend
+
 	prerouting  *chain.ChainBuilder
 	input       *chain.ChainBuilder
 	output      *chain.ChainBuilder
@@ -78,6 +103,13 @@ type NatTable struct {
 	chains []*chain.ChainBuilder
 }
 
+// Family returns the address family this table was built for, so callers
+// know whether to feed the rendered script to iptables-restore or
+// ip6tables-restore.
+func (t *NatTable) Family() AddressFamily {
+	return t.family
+}
+
 func (t *NatTable) Prerouting() *chain.ChainBuilder {
 	return t.prerouting
 }
@@ -103,6 +135,7 @@ func (t *NatTable) AddChain(chain *chain.ChainBuilder) *NatTable {
 func (t *NatTable) Build(verbose bool) string {
 	table := &TableBuilder{
 		name:      "nat",
+		family:    t.family,
 		newChains: t.chains,
 		chains: []*chain.ChainBuilder{
 			t.prerouting,
@@ -116,7 +149,19 @@ func (t *NatTable) Build(verbose bool) string {
 }
 
 func Nat() *NatTable {
+	return natForFamily(IPv4)
+}
+
+// Nat6 builds the IPv6 counterpart of Nat: the same PREROUTING/INPUT/
+// OUTPUT/POSTROUTING chains, rendered for consumption by
+// ip6tables-restore instead of iptables-restore.
+func Nat6() *NatTable {
+	return natForFamily(IPv6)
+}
+
+func natForFamily(family AddressFamily) *NatTable {
 	return &NatTable{
+		family:      family,
 		prerouting:  chain.NewChain("PREROUTING"),
 		input:       chain.NewChain("INPUT"),
 		output:      chain.NewChain("OUTPUT"),